@@ -0,0 +1,60 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package config
+
+// ContractCacheSize is the configured byte budget for the shared, per-backend
+// deserialized-contract cache (see smartcontract/storage.contractCache). Zero
+// means unset: callers fall back to their own built-in default.
+var ContractCacheSize int
+
+// GetContractCacheSize returns the configured contract cache budget in bytes,
+// or 0 if it was never set.
+func GetContractCacheSize() int {
+	return ContractCacheSize
+}
+
+// DEFAULT_COLD_STORE_DIR is used by NewColdStore when ColdStoreDir is unset.
+const DEFAULT_COLD_STORE_DIR = "./Chain/coldstore"
+
+// ColdStoreDir is the configured root directory for the destroyed-contract
+// cold store (see smartcontract/storage.ColdStore). Empty means unset:
+// GetColdStoreDir falls back to DEFAULT_COLD_STORE_DIR.
+var ColdStoreDir string
+
+// GetColdStoreDir returns the configured cold store directory, or
+// DEFAULT_COLD_STORE_DIR if it was never set.
+func GetColdStoreDir() string {
+	if ColdStoreDir == "" {
+		return DEFAULT_COLD_STORE_DIR
+	}
+	return ColdStoreDir
+}
+
+// TrackDestroyedContractHeight is the height below which destroyed-contract
+// records may be pruned from the cold store, and below which RewindTo
+// refuses to rewind (see smartcontract/storage.ColdStore and
+// CacheDB.RewindTo). Zero, the default, retains destruction history back to
+// genesis.
+var TrackDestroyedContractHeight uint32
+
+// GetTrackDestroyedContractHeight returns the configured prune/rewind floor
+// for destroyed-contract history.
+func GetTrackDestroyedContractHeight() uint32 {
+	return TrackDestroyedContractHeight
+}
@@ -19,6 +19,8 @@
 package storage
 
 import (
+	"encoding/binary"
+
 	comm "github.com/ontio/ontology/common"
 	"github.com/ontio/ontology/common/config"
 	"github.com/ontio/ontology/core/payload"
@@ -33,6 +35,28 @@ type CacheDB struct {
 	memdb      *overlaydb.MemDB
 	backend    *overlaydb.OverlayDB
 	keyScratch []byte
+	journal    []journalEntry
+	diffSink   func(*StateDiff)
+	cold       ColdStore
+	contracts  *contractCache
+}
+
+// SetColdStore attaches a ColdStore that IsContractDestroyed consults for
+// destruction markers not found in the memdb or the overlay, and that Commit
+// and CommitWithDiff append committed destruction markers to. A nil cold
+// store (the default) leaves behavior unchanged.
+func (self *CacheDB) SetColdStore(cold ColdStore) {
+	self.cold = cold
+}
+
+// journalEntry records the value a key held in the memdb immediately before
+// a mutation overwrote it, so RevertToSnapshot can put it back. unknown is
+// set when the key had no entry in the memdb at all, as opposed to holding
+// a known, empty value.
+type journalEntry struct {
+	key     []byte
+	value   []byte
+	unknown bool
 }
 
 const initCap = 1024
@@ -41,13 +65,60 @@ const initKvNum = 16
 // NewCacheDB return a new contract cache
 func NewCacheDB(store *overlaydb.OverlayDB) *CacheDB {
 	return &CacheDB{
-		backend: store,
-		memdb:   overlaydb.NewMemDB(initCap, initKvNum),
+		backend:   store,
+		memdb:     overlaydb.NewMemDB(initCap, initKvNum),
+		contracts: contractCacheFor(store),
 	}
 }
 
 func (self *CacheDB) Reset() {
 	self.memdb.Reset()
+	self.journal = self.journal[:0]
+}
+
+// Snapshot returns an id identifying the current state of the cache. Pass it
+// to RevertToSnapshot to undo every mutation recorded since this call.
+func (self *CacheDB) Snapshot() int {
+	return len(self.journal)
+}
+
+// RevertToSnapshot rolls the memdb back to the state it had when the given
+// snapshot id was taken, undoing journaled mutations in LIFO order. Reverting
+// to an id invalidates any snapshot taken after it.
+func (self *CacheDB) RevertToSnapshot(id int) {
+	// memdb.Delete leaves a known, empty delete marker, so keys that had no
+	// memdb entry at all are dropped by rebuilding the memdb without them.
+	drop := make(map[string]bool)
+	for i := len(self.journal) - 1; i >= id; i-- {
+		entry := self.journal[i]
+		if entry.unknown {
+			drop[string(entry.key)] = true
+		} else {
+			delete(drop, string(entry.key))
+			self.memdb.Put(entry.key, entry.value)
+		}
+	}
+	if len(drop) > 0 {
+		fresh := overlaydb.NewMemDB(initCap, initKvNum)
+		self.memdb.ForEach(func(key, val []byte) {
+			if !drop[string(key)] {
+				fresh.Put(key, val)
+			}
+		})
+		self.memdb = fresh
+	}
+	self.journal = self.journal[:id]
+}
+
+// record appends a journal entry capturing the memdb's current value for key,
+// before it gets overwritten by the caller.
+func (self *CacheDB) record(key []byte) {
+	value, unknown := self.memdb.Get(key)
+	entry := journalEntry{key: append([]byte(nil), key...), unknown: unknown}
+	if !unknown {
+		entry.value = append([]byte(nil), value...)
+	}
+	self.journal = append(self.journal, entry)
 }
 
 func ensureBuffer(b []byte, n int) []byte {
@@ -64,15 +135,48 @@ func makePrefixedKey(dst []byte, prefix byte, key []byte) []byte {
 	return dst
 }
 
-// Commit current transaction cache to block cache
-func (self *CacheDB) Commit() {
+// Commit current transaction cache to block cache. It returns an error if a
+// destroyed-contract record fails to flush to the attached cold store (see
+// flushColdStore) - the backend writes themselves cannot fail.
+func (self *CacheDB) Commit() error {
+	var err error
 	self.memdb.ForEach(func(key, val []byte) {
+		if err != nil {
+			return
+		}
+
 		if len(val) == 0 {
 			self.backend.Delete(key)
 		} else {
 			self.backend.Put(key, val)
 		}
+
+		err = self.flushColdStore(key, val)
 	})
+	if err != nil {
+		return err
+	}
+	self.journal = self.journal[:0]
+	return nil
+}
+
+// flushColdStore appends a committed ST_DESTROYED record to the attached cold
+// store, if any. It must only run once a key has actually been written to the
+// backend: SetContractDestroyed itself no longer touches the cold store, so a
+// destroy that is later undone via RevertToSnapshot, or thrown away with
+// Reset, never reaches cold storage's permanent record in the first place.
+func (self *CacheDB) flushColdStore(key, val []byte) error {
+	if self.cold == nil || len(val) < 4 {
+		return nil
+	}
+	if common.DataEntryPrefix(key[0]) != common.ST_DESTROYED {
+		return nil
+	}
+
+	var addr comm.Address
+	copy(addr[:], key[1:])
+	height := binary.LittleEndian.Uint32(val)
+	return self.cold.AppendDestroyed(height, addr)
 }
 
 func (self *CacheDB) Put(key []byte, value []byte) {
@@ -81,9 +185,17 @@ func (self *CacheDB) Put(key []byte, value []byte) {
 
 func (self *CacheDB) put(prefix common.DataEntryPrefix, key []byte, value []byte) {
 	self.keyScratch = makePrefixedKey(self.keyScratch, byte(prefix), key)
+	self.record(self.keyScratch)
 	self.memdb.Put(self.keyScratch, value)
 }
 
+// GetContract only consults and populates the shared contract cache for
+// values confirmed to come from the committed backend, never from the
+// in-flight memdb: if the current transaction has an uncommitted write for
+// addr (a deploy, a destroy, or a migration), that value is speculative and
+// must not leak into a cache shared with every other CacheDB on the same
+// backend, since it could still be rolled back via RevertToSnapshot or
+// discarded entirely via Reset.
 func (self *CacheDB) GetContract(addr comm.Address) (*payload.DeployCode, bool, error) {
 	destroyed, err := self.IsContractDestroyed(addr)
 	if err != nil {
@@ -93,15 +205,33 @@ func (self *CacheDB) GetContract(addr comm.Address) (*payload.DeployCode, bool,
 		return nil, true, nil
 	}
 
-	value, err := self.get(common.ST_CONTRACT, addr[:])
+	self.keyScratch = makePrefixedKey(self.keyScratch, byte(common.ST_CONTRACT), addr[:])
+	value, unknown := self.memdb.Get(self.keyScratch)
+	if !unknown {
+		return self.deserializeContract(value)
+	}
+
+	if contract, ok := self.contracts.get(addr); ok {
+		return contract, false, nil
+	}
+
+	value, err = self.backend.Get(self.keyScratch)
 	if err != nil {
 		return nil, false, err
 	}
 
+	contract, _, err := self.deserializeContract(value)
+	if err != nil || contract == nil {
+		return contract, false, err
+	}
+	self.contracts.put(addr, contract, len(value))
+	return contract, false, nil
+}
+
+func (self *CacheDB) deserializeContract(value []byte) (*payload.DeployCode, bool, error) {
 	if len(value) == 0 {
 		return nil, false, nil
 	}
-
 	contract := new(payload.DeployCode)
 	if err := contract.Deserialization(comm.NewZeroCopySource(value)); err != nil {
 		return nil, false, err
@@ -117,22 +247,38 @@ func (self *CacheDB) PutContract(contract *payload.DeployCode) {
 
 	value := sink.Bytes()
 	self.put(common.ST_CONTRACT, address[:], value)
+	self.contracts.remove(address)
 }
 
+// IsContractDestroyed checks the memdb, then the overlay, and finally, if
+// neither has an answer, the cold store - so in-flight transactions still see
+// destruction recorded earlier in the same, not-yet-committed cache.
 func (self *CacheDB) IsContractDestroyed(addr comm.Address) (bool, error) {
 	value, err := self.get(common.ST_DESTROYED, addr[:])
 	if err != nil {
 		return true, err
 	}
+	if len(value) != 0 {
+		return true, nil
+	}
 
-	return len(value) != 0, nil
+	if self.cold != nil {
+		if _, ok := self.cold.LookupDestroyed(addr); ok {
+			return true, nil
+		}
+	}
+	return false, nil
 }
 
 func (self *CacheDB) DeleteContract(address comm.Address, height uint32) {
 	self.delete(common.ST_CONTRACT, address[:])
 	self.SetContractDestroyed(address, height)
+	self.contracts.remove(address)
 }
 
+// SetContractDestroyed only marks the memdb; the record only reaches the
+// cold store once Commit (or CommitWithDiff) actually flushes it, see
+// flushColdStore.
 func (self *CacheDB) SetContractDestroyed(addr comm.Address, height uint32) {
 	if config.GetTrackDestroyedContractHeight() <= height {
 		sink := comm.NewZeroCopySink(nil)
@@ -141,6 +287,12 @@ func (self *CacheDB) SetContractDestroyed(addr comm.Address, height uint32) {
 	}
 }
 
+// UnsetContractDestroyed only clears the hot memdb entry: destruction records
+// in the cold store are permanent once written, and in practice this only
+// ever cancels a SetContractDestroyed from the same, not-yet-committed
+// transaction, whose marker never reached the cold store (flushColdStore
+// only runs on commit), so IsContractDestroyed falling through to the cold
+// store still reports the contract as not destroyed.
 func (self *CacheDB) UnsetContractDestroyed(addr comm.Address, height uint32) {
 	if config.GetTrackDestroyedContractHeight() <= height {
 		self.delete(common.ST_DESTROYED, addr[:])
@@ -172,6 +324,7 @@ func (self *CacheDB) Delete(key []byte) {
 // Delete item from cache
 func (self *CacheDB) delete(prefix common.DataEntryPrefix, key []byte) {
 	self.keyScratch = makePrefixedKey(self.keyScratch, byte(prefix), key)
+	self.record(self.keyScratch)
 	self.memdb.Delete(self.keyScratch)
 }
 
@@ -200,6 +353,7 @@ func (self *Iter) Key() []byte {
 
 func (self *CacheDB) MigrateContractStorage(oldAddress, newAddress comm.Address, height uint32) error {
 	self.DeleteContract(oldAddress, height)
+	self.contracts.remove(newAddress)
 
 	iter := self.NewIterator(oldAddress[:])
 	for has := iter.First(); has; has = iter.Next() {
@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ontio/ontology/core/store/common"
+)
+
+// TestCacheDBImportSnapshotRejectsCorruptChecksum exercises the failure path
+// ImportSnapshot is documented to guarantee: the CRC32C footer is checked
+// before the memdb is reset or the backend is touched at all, so a corrupt
+// stream must leave existing state untouched. This only needs a MemDB, since
+// a rejected import never reaches self.backend.
+func TestCacheDBImportSnapshotRejectsCorruptChecksum(t *testing.T) {
+	cache := newJournalTestCacheDB()
+	cache.Put([]byte("sentinel"), []byte("untouched"))
+	id := cache.Snapshot()
+
+	var buf bytes.Buffer
+	if err := writeSnapshotRecord(&buf, make([]byte, 4), []byte("k"), []byte("v")); err != nil {
+		t.Fatalf("writeSnapshotRecord: %v", err)
+	}
+	var endMarker [4]byte
+	for i := range endMarker {
+		endMarker[i] = 0xff
+	}
+	buf.Write(endMarker[:])
+	buf.Write([]byte{0, 0, 0, 0}) // wrong CRC: the real footer is never all-zero here
+
+	if err := cache.ImportSnapshot(&buf); err == nil {
+		t.Fatalf("expected ImportSnapshot to reject a corrupt checksum")
+	}
+
+	if cache.Snapshot() != id {
+		t.Fatalf("expected a rejected import to leave the journal untouched")
+	}
+	got, unknown := cache.memdb.Get(makePrefixedKey(nil, byte(common.ST_STORAGE), []byte("sentinel")))
+	if unknown || !bytes.Equal(got, []byte("untouched")) {
+		t.Fatalf("expected the sentinel key to survive a rejected import, got %q, unknown=%v", got, unknown)
+	}
+}
+
+// TestCacheDBImportSnapshotRejectsTruncatedStream checks that ImportSnapshot
+// returns an error when the stream is cut off mid-record rather than merely
+// having a bad checksum.
+func TestCacheDBImportSnapshotRejectsTruncatedStream(t *testing.T) {
+	cache := newJournalTestCacheDB()
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 1}) // claims a 1-byte key, then the stream ends
+
+	if err := cache.ImportSnapshot(&buf); err == nil {
+		t.Fatalf("expected ImportSnapshot to reject a truncated stream")
+	}
+}
+
+// NOTE: a full ExportSnapshot/ImportSnapshot round trip against a real
+// *overlaydb.OverlayDB isn't covered here, since building one needs the
+// underlying persistent store this package is tested against, which lives
+// outside this package.
@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	comm "github.com/ontio/ontology/common"
+)
+
+// fakeDiffStore is a minimal StateDiffStore that fails at a chosen height, so
+// RewindTo can be exercised partway through a multi-height rewind.
+type fakeDiffStore struct {
+	height uint32
+	diffs  map[uint32]*StateDiff
+	errAt  uint32
+}
+
+func (f *fakeDiffStore) Height() uint32 { return f.height }
+
+func (f *fakeDiffStore) DiffAt(h uint32) (*StateDiff, bool, error) {
+	if h == f.errAt {
+		return nil, false, errors.New("boom")
+	}
+	diff, ok := f.diffs[h]
+	return diff, ok, nil
+}
+
+func TestCacheDBRewindToDefersColdStoreRemovalUntilTheWholeRewindSucceeds(t *testing.T) {
+	cache := newJournalTestCacheDB()
+	cold := openTestColdStore(t)
+	cache.SetColdStore(cold)
+
+	addr9, addr10 := testAddress(9), testAddress(10)
+	if err := cold.AppendDestroyed(9, addr9); err != nil {
+		t.Fatalf("AppendDestroyed: %v", err)
+	}
+	if err := cold.AppendDestroyed(10, addr10); err != nil {
+		t.Fatalf("AppendDestroyed: %v", err)
+	}
+
+	diffs := &fakeDiffStore{
+		height: 10,
+		errAt:  8,
+		diffs: map[uint32]*StateDiff{
+			9: {ContractDestroys: []struct {
+				Addr   comm.Address
+				Height uint32
+			}{{Addr: addr9, Height: 9}}},
+			10: {ContractDestroys: []struct {
+				Addr   comm.Address
+				Height uint32
+			}{{Addr: addr10, Height: 10}}},
+		},
+	}
+
+	if err := cache.RewindTo(7, diffs); err == nil {
+		t.Fatalf("expected RewindTo to surface the error from the failing height")
+	}
+
+	if _, ok := cold.LookupDestroyed(addr9); !ok {
+		t.Fatalf("expected addr9's cold-store tombstone to be withheld after a failed rewind")
+	}
+	if _, ok := cold.LookupDestroyed(addr10); !ok {
+		t.Fatalf("expected addr10's cold-store tombstone to be withheld after a failed rewind")
+	}
+}
+
+func TestCacheDBRewindToRemovesColdStoreEntriesOnSuccess(t *testing.T) {
+	cache := newJournalTestCacheDB()
+	cold := openTestColdStore(t)
+	cache.SetColdStore(cold)
+
+	addr := testAddress(11)
+	if err := cold.AppendDestroyed(10, addr); err != nil {
+		t.Fatalf("AppendDestroyed: %v", err)
+	}
+
+	diffs := &fakeDiffStore{
+		height: 10,
+		diffs: map[uint32]*StateDiff{
+			10: {ContractDestroys: []struct {
+				Addr   comm.Address
+				Height uint32
+			}{{Addr: addr, Height: 10}}},
+		},
+	}
+
+	if err := cache.RewindTo(5, diffs); err != nil {
+		t.Fatalf("RewindTo: %v", err)
+	}
+
+	if _, ok := cold.LookupDestroyed(addr); ok {
+		t.Fatalf("expected addr to be un-destroyed after a fully successful rewind")
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ontio/ontology/core/store/common"
+	"github.com/ontio/ontology/core/store/overlaydb"
+)
+
+// newJournalTestCacheDB builds a CacheDB backed only by a MemDB, with no
+// backend OverlayDB. Snapshot/RevertToSnapshot never touch the backend, so
+// this is enough to exercise the journal without needing a real store.
+func newJournalTestCacheDB() *CacheDB {
+	return &CacheDB{memdb: overlaydb.NewMemDB(initCap, initKvNum)}
+}
+
+func TestCacheDBRevertToSnapshotRestoresPriorValue(t *testing.T) {
+	cache := newJournalTestCacheDB()
+
+	cache.Put([]byte("key"), []byte("first"))
+	id := cache.Snapshot()
+	cache.Put([]byte("key"), []byte("second"))
+
+	cache.RevertToSnapshot(id)
+
+	got, unknown := cache.memdb.Get(makePrefixedKey(nil, byte(common.ST_STORAGE), []byte("key")))
+	if unknown {
+		t.Fatalf("expected key to still be known after reverting to a snapshot taken after it was set")
+	}
+	if !bytes.Equal(got, []byte("first")) {
+		t.Fatalf("expected reverted value %q, got %q", "first", got)
+	}
+}
+
+func TestCacheDBRevertToSnapshotUndoesNewKey(t *testing.T) {
+	cache := newJournalTestCacheDB()
+
+	id := cache.Snapshot()
+	cache.Put([]byte("key"), []byte("value"))
+
+	cache.RevertToSnapshot(id)
+
+	_, unknown := cache.memdb.Get(makePrefixedKey(nil, byte(common.ST_STORAGE), []byte("key")))
+	if !unknown {
+		t.Fatalf("expected key introduced after the snapshot to be gone after reverting")
+	}
+}
+
+func TestCacheDBRevertToSnapshotIsLIFOAcrossNestedSnapshots(t *testing.T) {
+	cache := newJournalTestCacheDB()
+
+	cache.Put([]byte("key"), []byte("v1"))
+	outer := cache.Snapshot()
+	cache.Put([]byte("key"), []byte("v2"))
+	inner := cache.Snapshot()
+	cache.Put([]byte("key"), []byte("v3"))
+
+	cache.RevertToSnapshot(inner)
+	got, _ := cache.memdb.Get(makePrefixedKey(nil, byte(common.ST_STORAGE), []byte("key")))
+	if !bytes.Equal(got, []byte("v2")) {
+		t.Fatalf("expected %q after reverting the inner snapshot, got %q", "v2", got)
+	}
+
+	cache.RevertToSnapshot(outer)
+	got, _ = cache.memdb.Get(makePrefixedKey(nil, byte(common.ST_STORAGE), []byte("key")))
+	if !bytes.Equal(got, []byte("v1")) {
+		t.Fatalf("expected %q after reverting the outer snapshot, got %q", "v1", got)
+	}
+}
+
+func TestCacheDBResetClearsJournalAndMemDB(t *testing.T) {
+	cache := newJournalTestCacheDB()
+
+	cache.Put([]byte("key"), []byte("value"))
+	cache.Reset()
+
+	if len(cache.journal) != 0 {
+		t.Fatalf("expected journal to be empty after Reset, got %d entries", len(cache.journal))
+	}
+	_, unknown := cache.memdb.Get(makePrefixedKey(nil, byte(common.ST_STORAGE), []byte("key")))
+	if !unknown {
+		t.Fatalf("expected memdb to be empty after Reset")
+	}
+}
@@ -0,0 +1,347 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	comm "github.com/ontio/ontology/common"
+	"github.com/ontio/ontology/common/config"
+	"github.com/ontio/ontology/core/store/common"
+	"github.com/ontio/ontology/core/store/overlaydb"
+)
+
+// addrLen is the width in bytes of a comm.Address, used to size cold store
+// records without hardcoding the address format in two places.
+const addrLen = len(comm.Address{})
+
+// ColdStore holds immutable destroyed-contract records. Commit appends each
+// committed ST_DESTROYED marker here as well as writing it to the backend;
+// MigrateDestroyedToColdStore moves existing rows out of the hot overlay.
+type ColdStore interface {
+	AppendDestroyed(height uint32, addr comm.Address) error
+	LookupDestroyed(addr comm.Address) (height uint32, ok bool)
+	// RemoveDestroyed un-marks addr as destroyed, for RewindTo to re-seed the
+	// destroyed-contract set when it rewinds past the height a contract was
+	// destroyed at. Cold records are otherwise permanent, so this appends a
+	// tombstone rather than mutating history in place.
+	RemoveDestroyed(addr comm.Address) error
+	Range(fn func(height uint32, addr comm.Address) bool)
+	Close() error
+}
+
+const (
+	coldRecordSize        = 4 + addrLen // height + address
+	coldSegmentHeaderLen  = 4           // max height seen in the segment
+	coldSegmentMaxRecords = 1 << 20
+	coldSegmentPrefix     = "destroyed-"
+	coldSegmentSuffix     = ".cdat"
+)
+
+// coldTombstoneHeight is the height value RemoveDestroyed writes to mark an
+// address as no longer destroyed. It can never collide with a real height,
+// which is bounded by the chain, and it is never allowed to bump a segment's
+// maxHeight (that would pin the segment and block pruning forever).
+const coldTombstoneHeight = ^uint32(0)
+
+// coldSegment is one rotating append-only file of fixed-width records.
+type coldSegment struct {
+	seq          int
+	path         string
+	file         *os.File
+	maxHeight    uint32
+	records      int
+	hasTombstone bool
+}
+
+// freezerColdStore is the default ColdStore: a set of rotating append-only
+// segment files under a configurable directory, with an in-memory address ->
+// height index rebuilt from the segment headers and bodies on startup. This
+// is the same freezer-style layout used for immutable chain data elsewhere.
+type freezerColdStore struct {
+	mu       sync.RWMutex
+	dir      string
+	index    map[comm.Address]uint32
+	segments []*coldSegment
+}
+
+// NewColdStore opens (creating if necessary) the cold store rooted at dir,
+// rebuilding its in-memory index by scanning existing segment files, and
+// pruning any segment that falls entirely below
+// config.GetTrackDestroyedContractHeight().
+func NewColdStore(dir string) (ColdStore, error) {
+	if dir == "" {
+		dir = config.GetColdStoreDir()
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("coldstore: create dir: %w", err)
+	}
+
+	cs := &freezerColdStore{dir: dir, index: make(map[comm.Address]uint32)}
+	if err := cs.load(); err != nil {
+		return nil, err
+	}
+	if err := cs.prune(config.GetTrackDestroyedContractHeight()); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+func (self *freezerColdStore) load() error {
+	entries, err := os.ReadDir(self.dir)
+	if err != nil {
+		return fmt.Errorf("coldstore: read dir: %w", err)
+	}
+
+	var segs []*coldSegment
+	for _, entry := range entries {
+		var seq int
+		if _, err := fmt.Sscanf(entry.Name(), coldSegmentPrefix+"%08d"+coldSegmentSuffix, &seq); err != nil {
+			continue
+		}
+		segs = append(segs, &coldSegment{seq: seq, path: filepath.Join(self.dir, entry.Name())})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].seq < segs[j].seq })
+
+	for _, seg := range segs {
+		f, err := os.OpenFile(seg.path, os.O_RDWR, 0640)
+		if err != nil {
+			return fmt.Errorf("coldstore: open segment %d: %w", seg.seq, err)
+		}
+		seg.file = f
+
+		header := make([]byte, coldSegmentHeaderLen)
+		if _, err := f.ReadAt(header, 0); err != nil {
+			return fmt.Errorf("coldstore: read segment %d header: %w", seg.seq, err)
+		}
+		seg.maxHeight = binary.BigEndian.Uint32(header)
+
+		if err := scanSegmentRecords(seg, self.index); err != nil {
+			return fmt.Errorf("coldstore: scan segment %d: %w", seg.seq, err)
+		}
+		self.segments = append(self.segments, seg)
+	}
+	return nil
+}
+
+// scanSegmentRecords reads every record in seg (updating seg.records and
+// seg.hasTombstone) and applies it to index: a real height sets index[addr],
+// a tombstone deletes it. It is shared by load, which builds the index from
+// scratch, and prune, which rebuilds it after dropping segments.
+func scanSegmentRecords(seg *coldSegment, index map[comm.Address]uint32) error {
+	seg.records = 0
+	seg.hasTombstone = false
+	body := make([]byte, coldRecordSize)
+	off := int64(coldSegmentHeaderLen)
+	for {
+		n, _ := seg.file.ReadAt(body, off)
+		if n != coldRecordSize {
+			return nil
+		}
+		height := binary.BigEndian.Uint32(body[:4])
+		var addr comm.Address
+		copy(addr[:], body[4:])
+		if height == coldTombstoneHeight {
+			delete(index, addr)
+			seg.hasTombstone = true
+		} else {
+			index[addr] = height
+		}
+		seg.records++
+		off += coldRecordSize
+	}
+}
+
+func (self *freezerColdStore) currentSegment() (*coldSegment, error) {
+	if n := len(self.segments); n > 0 && self.segments[n-1].records < coldSegmentMaxRecords {
+		return self.segments[n-1], nil
+	}
+	seq := 0
+	if n := len(self.segments); n > 0 {
+		seq = self.segments[n-1].seq + 1
+	}
+	path := filepath.Join(self.dir, fmt.Sprintf("%s%08d%s", coldSegmentPrefix, seq, coldSegmentSuffix))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("coldstore: create segment %d: %w", seq, err)
+	}
+	if _, err := f.Write(make([]byte, coldSegmentHeaderLen)); err != nil {
+		return nil, fmt.Errorf("coldstore: init segment %d header: %w", seq, err)
+	}
+	seg := &coldSegment{seq: seq, path: path, file: f}
+	self.segments = append(self.segments, seg)
+	return seg, nil
+}
+
+// AppendDestroyed records addr as destroyed at height. Destruction records
+// are permanent: once written here a later UnsetContractDestroyed only
+// affects the hot path (see CacheDB.IsContractDestroyed), since in practice
+// it only ever cancels a Set from the same, not-yet-committed transaction.
+// Use RemoveDestroyed to actually undo a committed destruction, e.g. when
+// RewindTo rewinds past the height it happened at.
+func (self *freezerColdStore) AppendDestroyed(height uint32, addr comm.Address) error {
+	return self.appendRecord(height, addr)
+}
+
+// RemoveDestroyed appends a tombstone record for addr, so that subsequent
+// LookupDestroyed calls - including after a process restart, once load has
+// rescanned the segments - report it as not destroyed.
+func (self *freezerColdStore) RemoveDestroyed(addr comm.Address) error {
+	return self.appendRecord(coldTombstoneHeight, addr)
+}
+
+func (self *freezerColdStore) appendRecord(height uint32, addr comm.Address) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	seg, err := self.currentSegment()
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, coldRecordSize)
+	binary.BigEndian.PutUint32(record[:4], height)
+	copy(record[4:], addr[:])
+	if _, err := seg.file.WriteAt(record, coldSegmentHeaderLen+int64(seg.records)*coldRecordSize); err != nil {
+		return fmt.Errorf("coldstore: append record: %w", err)
+	}
+	seg.records++
+
+	if height != coldTombstoneHeight && height > seg.maxHeight {
+		seg.maxHeight = height
+		header := make([]byte, coldSegmentHeaderLen)
+		binary.BigEndian.PutUint32(header, seg.maxHeight)
+		if _, err := seg.file.WriteAt(header, 0); err != nil {
+			return fmt.Errorf("coldstore: update segment header: %w", err)
+		}
+	}
+
+	if height == coldTombstoneHeight {
+		delete(self.index, addr)
+		seg.hasTombstone = true
+	} else {
+		self.index[addr] = height
+	}
+	return nil
+}
+
+func (self *freezerColdStore) LookupDestroyed(addr comm.Address) (uint32, bool) {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	height, ok := self.index[addr]
+	return height, ok
+}
+
+func (self *freezerColdStore) Range(fn func(height uint32, addr comm.Address) bool) {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	for addr, height := range self.index {
+		if !fn(height, addr) {
+			return
+		}
+	}
+}
+
+// prune truncates whole segment files whose records are all below height,
+// freeing the disk space of destruction markers nobody can query for
+// anymore, and rebuilds the in-memory index from what's left so a pruned
+// address stops being reported as destroyed. A segment holding a tombstone
+// is never pruned by height, even if its maxHeight is still its zero value:
+// RemoveDestroyed tombstones carry no height of their own (appendRecord
+// never bumps maxHeight for them), so a segment that happens to hold only
+// tombstones can't be distinguished from an empty one by height alone - and
+// wrongly dropping it would silently resurrect the destruction the
+// tombstone was written to undo, once an earlier, still-kept segment's
+// original AppendDestroyed record is all that's left in the index.
+func (self *freezerColdStore) prune(height uint32) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	kept := self.segments[:0]
+	for _, seg := range self.segments {
+		if seg.maxHeight < height && !seg.hasTombstone {
+			if err := seg.file.Close(); err != nil {
+				return fmt.Errorf("coldstore: close segment %d for pruning: %w", seg.seq, err)
+			}
+			if err := os.Remove(seg.path); err != nil {
+				return fmt.Errorf("coldstore: remove pruned segment %d: %w", seg.seq, err)
+			}
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	self.segments = kept
+
+	index := make(map[comm.Address]uint32)
+	for _, seg := range self.segments {
+		if err := scanSegmentRecords(seg, index); err != nil {
+			return fmt.Errorf("coldstore: rebuild index after pruning segment %d: %w", seg.seq, err)
+		}
+	}
+	self.index = index
+	return nil
+}
+
+func (self *freezerColdStore) Close() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, seg := range self.segments {
+		if err := seg.file.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateDestroyedToColdStore moves ST_DESTROYED rows held in the hot overlay
+// into cold, for nodes upgrading from a version that only knew the LevelDB
+// path for destruction markers. It is safe to call on every startup: a row
+// whose address cold already holds is skipped and left in the overlay, and
+// once the hot overlay has
+// no ST_DESTROYED rows left it is a cheap no-op.
+func MigrateDestroyedToColdStore(overlay *overlaydb.OverlayDB, cold ColdStore) error {
+	iter := overlay.NewIterator([]byte{byte(common.ST_DESTROYED)})
+	defer iter.Release()
+
+	for has := iter.First(); has; has = iter.Next() {
+		key := iter.Key()
+		val := iter.Value()
+		if len(key) < 1+addrLen || len(val) < 4 {
+			continue
+		}
+
+		var addr comm.Address
+		copy(addr[:], key[1:])
+		if _, ok := cold.LookupDestroyed(addr); ok {
+			continue
+		}
+
+		height := binary.LittleEndian.Uint32(val)
+		if err := cold.AppendDestroyed(height, addr); err != nil {
+			return err
+		}
+		overlay.Delete(key)
+	}
+	return iter.Error()
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"container/list"
+	"reflect"
+	"runtime"
+	"sync"
+
+	comm "github.com/ontio/ontology/common"
+	"github.com/ontio/ontology/common/config"
+	"github.com/ontio/ontology/core/payload"
+	"github.com/ontio/ontology/core/store/overlaydb"
+)
+
+// defaultContractCacheSize is the byte budget for the shared deploy-code
+// cache, used when config.GetContractCacheSize does not override it.
+const defaultContractCacheSize = 64 * 1024 * 1024 // 64 MiB
+
+// contractCacheEntry is the value held by a list.Element in contractCache.
+type contractCacheEntry struct {
+	addr comm.Address
+	code *payload.DeployCode
+	size int
+}
+
+// contractCache is a size-bounded LRU cache of deserialized payload.DeployCode,
+// keyed by contract address. GetContract deserializes a contract's bytes on
+// every call otherwise, which is hot during contract-heavy blocks since every
+// CALL opcode reloads the callee. The *payload.DeployCode returned on a hit is
+// shared across callers, so it must not be mutated.
+type contractCache struct {
+	mu       sync.Mutex
+	ll       *list.List
+	items    map[comm.Address]*list.Element
+	size     int
+	capacity int
+}
+
+func newContractCache(capacity int) *contractCache {
+	return &contractCache{
+		ll:       list.New(),
+		items:    make(map[comm.Address]*list.Element),
+		capacity: capacity,
+	}
+}
+
+func (self *contractCache) get(addr comm.Address) (*payload.DeployCode, bool) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	elem, ok := self.items[addr]
+	if !ok {
+		return nil, false
+	}
+	self.ll.MoveToFront(elem)
+	return elem.Value.(*contractCacheEntry).code, true
+}
+
+func (self *contractCache) put(addr comm.Address, code *payload.DeployCode, size int) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if elem, ok := self.items[addr]; ok {
+		self.size -= elem.Value.(*contractCacheEntry).size
+		self.ll.Remove(elem)
+	}
+
+	elem := self.ll.PushFront(&contractCacheEntry{addr: addr, code: code, size: size})
+	self.items[addr] = elem
+	self.size += size
+
+	for self.size > self.capacity && self.ll.Len() > 0 {
+		self.removeOldest()
+	}
+}
+
+func (self *contractCache) remove(addr comm.Address) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	elem, ok := self.items[addr]
+	if !ok {
+		return
+	}
+	self.removeElement(elem)
+}
+
+func (self *contractCache) removeOldest() {
+	if elem := self.ll.Back(); elem != nil {
+		self.removeElement(elem)
+	}
+}
+
+func (self *contractCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*contractCacheEntry)
+	self.ll.Remove(elem)
+	delete(self.items, entry.addr)
+	self.size -= entry.size
+}
+
+// clear drops every cached entry. Used after a bulk state replace (see
+// CacheDB.ImportSnapshot), where cached bytecode can no longer be trusted to
+// match what's now in the backend.
+func (self *contractCache) clear() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.ll = list.New()
+	self.items = make(map[comm.Address]*list.Element)
+	self.size = 0
+}
+
+// contractCacheRegistry holds one contractCache per backend OverlayDB, so the
+// cache is shared across every CacheDB instance reading from the same
+// backend (e.g. the CacheDB created per transaction within a block) without
+// leaking committed contract code across unrelated backends - different
+// blocks, different chains, or anything else sharing this process.
+//
+// The registry is keyed by the backend's pointer address rather than the
+// *overlaydb.OverlayDB itself, so it never holds a strong reference to the
+// backend: a map keyed by the pointer would keep every backend (and its
+// cache) alive for the life of the process, since a new one is created per
+// block/transaction and never explicitly torn down. A finalizer attached to
+// each backend removes its entry once the backend itself becomes
+// unreachable.
+var (
+	contractCacheRegistryMu sync.Mutex
+	contractCacheRegistry   = make(map[uintptr]*contractCache)
+)
+
+// contractCacheFor returns the shared contractCache for backend, creating it
+// on first use. Creating it lazily, rather than at package init, means
+// contractCacheBudget reads config.GetContractCacheSize after config has
+// actually been loaded.
+func contractCacheFor(backend *overlaydb.OverlayDB) *contractCache {
+	key := reflect.ValueOf(backend).Pointer()
+
+	contractCacheRegistryMu.Lock()
+	defer contractCacheRegistryMu.Unlock()
+
+	if cache, ok := contractCacheRegistry[key]; ok {
+		return cache
+	}
+	cache := newContractCache(contractCacheBudget())
+	contractCacheRegistry[key] = cache
+	runtime.SetFinalizer(backend, func(*overlaydb.OverlayDB) {
+		contractCacheRegistryMu.Lock()
+		delete(contractCacheRegistry, key)
+		contractCacheRegistryMu.Unlock()
+	})
+	return cache
+}
+
+func contractCacheBudget() int {
+	if size := config.GetContractCacheSize(); size > 0 {
+		return size
+	}
+	return defaultContractCacheSize
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"runtime"
+	"testing"
+
+	comm "github.com/ontio/ontology/common"
+	"github.com/ontio/ontology/core/payload"
+	"github.com/ontio/ontology/core/store/overlaydb"
+)
+
+func testAddress(b byte) comm.Address {
+	var addr comm.Address
+	addr[0] = b
+	return addr
+}
+
+func TestContractCacheGetPutRemove(t *testing.T) {
+	c := newContractCache(1024)
+	addr := testAddress(1)
+	code := new(payload.DeployCode)
+
+	if _, ok := c.get(addr); ok {
+		t.Fatalf("expected miss on empty cache")
+	}
+
+	c.put(addr, code, 100)
+	got, ok := c.get(addr)
+	if !ok || got != code {
+		t.Fatalf("expected hit returning the same *DeployCode, got %v, %v", got, ok)
+	}
+
+	c.remove(addr)
+	if _, ok := c.get(addr); ok {
+		t.Fatalf("expected miss after remove")
+	}
+}
+
+func TestContractCacheEvictsOldestWhenOverBudget(t *testing.T) {
+	c := newContractCache(150)
+
+	addrA, addrB, addrC := testAddress(1), testAddress(2), testAddress(3)
+	c.put(addrA, new(payload.DeployCode), 100)
+	c.put(addrB, new(payload.DeployCode), 100)
+
+	// Budget is 150, so adding B (now at 200 total) must evict A, the least
+	// recently used entry.
+	if _, ok := c.get(addrA); ok {
+		t.Fatalf("expected addrA to have been evicted")
+	}
+	if _, ok := c.get(addrB); !ok {
+		t.Fatalf("expected addrB to still be cached")
+	}
+
+	// Touching B moves it to the front; adding C should now evict nothing
+	// else but whatever is oldest, which is still just B's slot budget-wise.
+	c.put(addrC, new(payload.DeployCode), 100)
+	if _, ok := c.get(addrB); ok {
+		t.Fatalf("expected addrB to have been evicted after addrC was added")
+	}
+	if _, ok := c.get(addrC); !ok {
+		t.Fatalf("expected addrC to still be cached")
+	}
+}
+
+func TestContractCacheClear(t *testing.T) {
+	c := newContractCache(1024)
+	addr := testAddress(1)
+	c.put(addr, new(payload.DeployCode), 10)
+
+	c.clear()
+
+	if _, ok := c.get(addr); ok {
+		t.Fatalf("expected cache to be empty after clear")
+	}
+	if c.size != 0 {
+		t.Fatalf("expected size to reset to 0, got %d", c.size)
+	}
+}
+
+func TestContractCacheForIsScopedPerBackend(t *testing.T) {
+	// contractCacheFor only ever keys off the backend's pointer identity, so
+	// two distinct zero-value OverlayDBs are enough to exercise the
+	// per-backend scoping without a working store behind them.
+	backendA, backendB := new(overlaydb.OverlayDB), new(overlaydb.OverlayDB)
+
+	cacheA1 := contractCacheFor(backendA)
+	cacheA2 := contractCacheFor(backendA)
+	cacheB := contractCacheFor(backendB)
+
+	if cacheA1 != cacheA2 {
+		t.Fatalf("expected the same backend to reuse the same contract cache")
+	}
+	if cacheA1 == cacheB {
+		t.Fatalf("expected different backends to get independent contract caches")
+	}
+}
+
+func TestContractCacheRegistryDropsEntryOnceBackendIsUnreachable(t *testing.T) {
+	contractCacheRegistryMu.Lock()
+	startSize := len(contractCacheRegistry)
+	contractCacheRegistryMu.Unlock()
+
+	func() {
+		backend := new(overlaydb.OverlayDB)
+		contractCacheFor(backend)
+	}()
+
+	// The backend above is already unreachable, but its finalizer - which
+	// removes its registry entry - is only guaranteed to have run after a
+	// GC cycle actually observes that.
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+
+		contractCacheRegistryMu.Lock()
+		size := len(contractCacheRegistry)
+		contractCacheRegistryMu.Unlock()
+		if size <= startSize {
+			return
+		}
+	}
+
+	t.Fatalf("expected the registry entry to be cleaned up once its backend became unreachable")
+}
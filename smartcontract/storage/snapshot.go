@@ -0,0 +1,239 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	comm "github.com/ontio/ontology/common"
+	"github.com/ontio/ontology/common/config"
+	"github.com/ontio/ontology/core/store/common"
+)
+
+// snapshotEndMarker is a reserved key-length value that terminates an
+// ExportSnapshot stream, since a real key length never reaches it.
+const snapshotEndMarker = ^uint32(0)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ExportSnapshot serializes every (key, value) record currently held by the
+// backing OverlayDB as a stream of length-prefixed records, sorted by raw key
+// order, followed by a CRC32C footer over everything written before it. The
+// stored key already carries its ST_* prefix byte, so ImportSnapshot can
+// write records straight back without having to know their kind.
+func (self *CacheDB) ExportSnapshot(w io.Writer) error {
+	crc := crc32.New(crc32cTable)
+	mw := io.MultiWriter(w, crc)
+
+	iter := self.backend.NewIterator(nil)
+	defer iter.Release()
+
+	var lenBuf [4]byte
+	for has := iter.First(); has; has = iter.Next() {
+		if err := writeSnapshotRecord(mw, lenBuf[:], iter.Key(), iter.Value()); err != nil {
+			return err
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(lenBuf[:], snapshotEndMarker)
+	if _, err := mw.Write(lenBuf[:]); err != nil {
+		return err
+	}
+
+	var footer [4]byte
+	binary.BigEndian.PutUint32(footer[:], crc.Sum32())
+	_, err := w.Write(footer[:])
+	return err
+}
+
+func writeSnapshotRecord(w io.Writer, lenBuf, key, value []byte) error {
+	binary.BigEndian.PutUint32(lenBuf, uint32(len(key)))
+	if _, err := w.Write(lenBuf); err != nil {
+		return err
+	}
+	if _, err := w.Write(key); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(lenBuf, uint32(len(value)))
+	if _, err := w.Write(lenBuf); err != nil {
+		return err
+	}
+	_, err := w.Write(value)
+	return err
+}
+
+// ImportSnapshot validates the CRC32C footer of a stream produced by
+// ExportSnapshot, then clears the transaction memdb and the entire backing
+// overlay and rewrites it from the stream, using the same per-record write
+// path as Commit. The whole stream is read and checksummed, and the existing
+// backend content enumerated, before anything is written or deleted - so a
+// truncated or corrupt snapshot leaves the existing state untouched. The
+// result replaces the overlay outright: any key present in the backend but
+// absent from the snapshot is removed, not merely shadowed.
+func (self *CacheDB) ImportSnapshot(r io.Reader) error {
+	crc := crc32.New(crc32cTable)
+	tr := io.TeeReader(r, crc)
+
+	type record struct{ key, value []byte }
+	var records []record
+
+	var lenBuf [4]byte
+	for {
+		if _, err := io.ReadFull(tr, lenBuf[:]); err != nil {
+			return fmt.Errorf("cachedb: read snapshot record length: %w", err)
+		}
+		keyLen := binary.BigEndian.Uint32(lenBuf[:])
+		if keyLen == snapshotEndMarker {
+			break
+		}
+
+		key := make([]byte, keyLen)
+		if _, err := io.ReadFull(tr, key); err != nil {
+			return fmt.Errorf("cachedb: read snapshot key: %w", err)
+		}
+
+		if _, err := io.ReadFull(tr, lenBuf[:]); err != nil {
+			return fmt.Errorf("cachedb: read snapshot value length: %w", err)
+		}
+		value := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+		if _, err := io.ReadFull(tr, value); err != nil {
+			return fmt.Errorf("cachedb: read snapshot value: %w", err)
+		}
+
+		records = append(records, record{key: key, value: value})
+	}
+
+	want := crc.Sum32()
+	var footer [4]byte
+	if _, err := io.ReadFull(r, footer[:]); err != nil {
+		return fmt.Errorf("cachedb: read snapshot footer: %w", err)
+	}
+	if got := binary.BigEndian.Uint32(footer[:]); got != want {
+		return fmt.Errorf("cachedb: snapshot checksum mismatch: got %08x, want %08x", got, want)
+	}
+
+	existing, err := self.collectBackendKeys()
+	if err != nil {
+		return err
+	}
+
+	self.memdb.Reset()
+	self.journal = self.journal[:0]
+	for _, key := range existing {
+		self.backend.Delete(key)
+	}
+	for _, rec := range records {
+		self.backend.Put(rec.key, rec.value)
+	}
+	self.contracts.clear()
+	return nil
+}
+
+// collectBackendKeys returns every key currently held by the backend, so
+// ImportSnapshot can delete them all before replaying the snapshot - without
+// this, keys absent from the snapshot but present in the backend would
+// survive the import as a leftover union of old and new state.
+func (self *CacheDB) collectBackendKeys() ([][]byte, error) {
+	iter := self.backend.NewIterator(nil)
+	defer iter.Release()
+
+	var keys [][]byte
+	for has := iter.First(); has; has = iter.Next() {
+		keys = append(keys, append([]byte(nil), iter.Key()...))
+	}
+	return keys, iter.Error()
+}
+
+// StateDiffStore supplies the StateDiffs recorded by past CommitWithDiff
+// calls, keyed by block height, so RewindTo can replay them backwards.
+type StateDiffStore interface {
+	// Height returns the height of the most recently recorded StateDiff.
+	Height() uint32
+	// DiffAt returns the StateDiff recorded at height, or ok=false if none
+	// was recorded there (e.g. before diffing was enabled).
+	DiffAt(height uint32) (diff *StateDiff, ok bool, err error)
+}
+
+// RewindTo undoes the effect of every StateDiff from diffs.Height() down to,
+// but not including, height, reconstructing the state as of height in-place.
+// Each StorageDiff is undone by restoring its OldValue (or deleting the key
+// if OldValue is empty); each ST_CONTRACT deploy is undone by deleting the
+// contract and evicting it from the shared contract cache; and destroyed-
+// contract markers are re-seeded via UnsetContractDestroyed, so IsContractDestroyed
+// stays consistent with the rewound height even for destructions that were
+// already flushed to cold storage. The attached cold store's RemoveDestroyed
+// is only called once every height in the range has been replayed
+// successfully, not per height as the loop goes: like flushColdStore, a cold
+// store write here is permanent, so staging it until the whole rewind has
+// succeeded keeps an error partway through from leaving a tombstone with no
+// corresponding change applied. The tombstones are written before RewindTo
+// returns, so a rewind that the caller later discards with Reset or
+// RevertToSnapshot still leaves them in the cold store. RewindTo refuses to rewind below
+// config.GetTrackDestroyedContractHeight(), since destruction history below
+// that point is not retained.
+func (self *CacheDB) RewindTo(height uint32, diffs StateDiffStore) error {
+	if trackHeight := config.GetTrackDestroyedContractHeight(); height < trackHeight {
+		return fmt.Errorf("cachedb: refusing to rewind below tracked-destroyed-contract height %d", trackHeight)
+	}
+
+	var toUndestroy []comm.Address
+	for h := diffs.Height(); h > height; h-- {
+		diff, ok, err := diffs.DiffAt(h)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		for _, sd := range diff.StorageDiffs {
+			key := serializeStorageKey(sd.Address, sd.Key)
+			if len(sd.OldValue) == 0 {
+				self.Delete(key)
+			} else {
+				self.Put(key, sd.OldValue)
+			}
+		}
+
+		for _, addr := range diff.ContractDeploys {
+			self.delete(common.ST_CONTRACT, addr[:])
+			self.contracts.remove(addr)
+		}
+
+		for _, destroyed := range diff.ContractDestroys {
+			self.UnsetContractDestroyed(destroyed.Addr, h)
+			toUndestroy = append(toUndestroy, destroyed.Addr)
+		}
+	}
+
+	if self.cold != nil {
+		for _, addr := range toUndestroy {
+			if err := self.cold.RemoveDestroyed(addr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
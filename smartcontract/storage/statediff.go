@@ -0,0 +1,149 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"encoding/binary"
+
+	comm "github.com/ontio/ontology/common"
+	"github.com/ontio/ontology/core/store/common"
+)
+
+// StorageDiff is the before/after pair for a single contract storage slot
+// touched by a Commit.
+type StorageDiff struct {
+	Address  comm.Address
+	Key      []byte
+	OldValue []byte
+	NewValue []byte
+}
+
+// StateDiff describes the keys a CommitWithDiff touched, split out by kind,
+// with the prior value read from the backend OverlayDB alongside the new one
+// for storage slots. Contract deletions and cleared destroyed-contract
+// markers are not reported. This
+// lets consumers such as RPC, off-chain indexers and archive sync observe
+// state transitions directly instead of re-scanning the trie.
+type StateDiff struct {
+	StorageDiffs     []StorageDiff
+	ContractDeploys  []comm.Address
+	ContractDestroys []struct {
+		Addr   comm.Address
+		Height uint32
+	}
+}
+
+// SetDiffSink registers fn to be called with the StateDiff produced by every
+// subsequent CommitWithDiff, so the ledger store can stream diffs to
+// downstream subsystems without every caller threading the result through by
+// hand.
+func (self *CacheDB) SetDiffSink(fn func(*StateDiff)) {
+	self.diffSink = fn
+}
+
+// pendingDiffEntry is a memdb entry paired with the prior value read from the
+// backend, captured before any backend write happens.
+type pendingDiffEntry struct {
+	key, val, old []byte
+}
+
+// CommitWithDiff commits the transaction cache to the block cache like
+// Commit, and additionally returns a StateDiff describing the keys touched
+// (see StateDiff for what it leaves out). It reads every prior value from the backend in
+// one pass over the memdb before writing anything, so a Get failure partway
+// through leaves the backend completely untouched instead of half-committed.
+func (self *CacheDB) CommitWithDiff() (*StateDiff, error) {
+	var entries []pendingDiffEntry
+	var err error
+	self.memdb.ForEach(func(key, val []byte) {
+		if err != nil {
+			return
+		}
+
+		old, getErr := self.backend.Get(key)
+		if getErr != nil {
+			err = getErr
+			return
+		}
+		entries = append(entries, pendingDiffEntry{
+			key: append([]byte(nil), key...),
+			val: append([]byte(nil), val...),
+			old: old,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &StateDiff{}
+	for _, e := range entries {
+		if len(e.val) == 0 {
+			self.backend.Delete(e.key)
+		} else {
+			self.backend.Put(e.key, e.val)
+		}
+
+		if err := self.flushColdStore(e.key, e.val); err != nil {
+			return nil, err
+		}
+
+		appendStateDiff(diff, e.key, e.old, e.val)
+	}
+
+	self.journal = self.journal[:0]
+
+	if self.diffSink != nil {
+		self.diffSink(diff)
+	}
+	return diff, nil
+}
+
+func appendStateDiff(diff *StateDiff, key, old, val []byte) {
+	prefix := common.DataEntryPrefix(key[0])
+	raw := key[1:]
+
+	switch prefix {
+	case common.ST_STORAGE:
+		var addr comm.Address
+		n := copy(addr[:], raw)
+		diff.StorageDiffs = append(diff.StorageDiffs, StorageDiff{
+			Address:  addr,
+			Key:      append([]byte(nil), raw[n:]...),
+			OldValue: old,
+			NewValue: val,
+		})
+	case common.ST_CONTRACT:
+		if len(val) == 0 {
+			return
+		}
+		var addr comm.Address
+		copy(addr[:], raw)
+		diff.ContractDeploys = append(diff.ContractDeploys, addr)
+	case common.ST_DESTROYED:
+		if len(val) < 4 {
+			return
+		}
+		var addr comm.Address
+		copy(addr[:], raw)
+		diff.ContractDestroys = append(diff.ContractDestroys, struct {
+			Addr   comm.Address
+			Height uint32
+		}{Addr: addr, Height: binary.LittleEndian.Uint32(val)})
+	}
+}
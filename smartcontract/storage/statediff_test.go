@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ontio/ontology/core/store/common"
+)
+
+func TestAppendStateDiffStorageCarriesBeforeAndAfter(t *testing.T) {
+	addr := testAddress(1)
+	storageKey := []byte("slot")
+	key := makePrefixedKey(nil, byte(common.ST_STORAGE), append(append([]byte{}, addr[:]...), storageKey...))
+
+	diff := &StateDiff{}
+	appendStateDiff(diff, key, []byte("old"), []byte("new"))
+
+	if len(diff.StorageDiffs) != 1 {
+		t.Fatalf("expected exactly one storage diff, got %d", len(diff.StorageDiffs))
+	}
+	sd := diff.StorageDiffs[0]
+	if sd.Address != addr {
+		t.Fatalf("expected address %v, got %v", addr, sd.Address)
+	}
+	if !bytes.Equal(sd.Key, storageKey) {
+		t.Fatalf("expected key %q, got %q", storageKey, sd.Key)
+	}
+	if string(sd.OldValue) != "old" || string(sd.NewValue) != "new" {
+		t.Fatalf("expected old=%q new=%q, got old=%q new=%q", "old", "new", sd.OldValue, sd.NewValue)
+	}
+}
+
+func TestAppendStateDiffStorageDeleteOfNeverSetKeyHasEmptyOld(t *testing.T) {
+	addr := testAddress(2)
+	key := makePrefixedKey(nil, byte(common.ST_STORAGE), append(append([]byte{}, addr[:]...), []byte("slot")...))
+
+	diff := &StateDiff{}
+	appendStateDiff(diff, key, nil, nil)
+
+	if len(diff.StorageDiffs) != 1 {
+		t.Fatalf("expected the diff to still be recorded even though nothing really changed")
+	}
+	if len(diff.StorageDiffs[0].OldValue) != 0 {
+		t.Fatalf("expected empty OldValue for a key that was never set, got %q", diff.StorageDiffs[0].OldValue)
+	}
+}
+
+func TestAppendStateDiffContractDeployOnlyOnNonEmptyValue(t *testing.T) {
+	addr := testAddress(3)
+	key := makePrefixedKey(nil, byte(common.ST_CONTRACT), addr[:])
+
+	diff := &StateDiff{}
+	appendStateDiff(diff, key, nil, []byte("code"))
+	if len(diff.ContractDeploys) != 1 || diff.ContractDeploys[0] != addr {
+		t.Fatalf("expected one deploy for %v, got %v", addr, diff.ContractDeploys)
+	}
+
+	diff = &StateDiff{}
+	appendStateDiff(diff, key, []byte("code"), nil)
+	if len(diff.ContractDeploys) != 0 {
+		t.Fatalf("expected a contract deletion not to be reported as a deploy")
+	}
+}
+
+func TestAppendStateDiffDestroyedDecodesHeight(t *testing.T) {
+	addr := testAddress(4)
+	key := makePrefixedKey(nil, byte(common.ST_DESTROYED), addr[:])
+
+	val := make([]byte, 4)
+	binary.LittleEndian.PutUint32(val, 123)
+
+	diff := &StateDiff{}
+	appendStateDiff(diff, key, nil, val)
+
+	if len(diff.ContractDestroys) != 1 {
+		t.Fatalf("expected one destroy entry, got %d", len(diff.ContractDestroys))
+	}
+	if diff.ContractDestroys[0].Addr != addr || diff.ContractDestroys[0].Height != 123 {
+		t.Fatalf("expected addr=%v height=123, got %+v", addr, diff.ContractDestroys[0])
+	}
+}